@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/coder/codersdk"
+)
+
+// Options configures a running agent.
+type Options struct {
+	Client  *codersdk.Client
+	AgentID uuid.UUID
+	// DataDir is where the agent persists state across restarts, including
+	// its SSH host key.
+	DataDir string
+}
+
+// Init prepares an agent to start serving connections: it loads (or
+// generates, on first run) the agent's persistent SSH host key and reports
+// its public half to coderd. It must run before the agent's SSH server
+// starts accepting connections, so that by the time a workspace is reachable
+// "coder config-ssh" already has a key to pin instead of falling back to
+// StrictHostKeyChecking=no.
+func Init(ctx context.Context, options Options) error {
+	signer, err := loadOrGenerateHostKey(options.DataDir)
+	if err != nil {
+		return xerrors.Errorf("load or generate ssh host key: %w", err)
+	}
+	if err := reportSSHHostKey(ctx, options.Client, options.AgentID, signer); err != nil {
+		return xerrors.Errorf("report ssh host key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/coder/codersdk"
+)
+
+// sshHostKeyFileName is where the agent persists its private host key, under
+// its data directory. Reusing the same key across agent restarts (rather
+// than generating a fresh one each time, like the agent's old ephemeral SSH
+// server did) is what lets "coder config-ssh" trust it via a known_hosts
+// entry instead of disabling host key verification.
+const sshHostKeyFileName = "host_key"
+
+// loadOrGenerateHostKey returns the agent's persistent SSH host key,
+// generating and saving a new ed25519 key on first run.
+func loadOrGenerateHostKey(dataDir string) (ssh.Signer, error) {
+	path := filepath.Join(dataDir, sshHostKeyFileName)
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(raw)
+	}
+	if !os.IsNotExist(err) {
+		return nil, xerrors.Errorf("read host key: %w", err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, xerrors.Errorf("generate host key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return nil, xerrors.Errorf("marshal host key: %w", err)
+	}
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, xerrors.Errorf("create data dir: %w", err)
+	}
+	// The private key never leaves the workspace, so it only needs to be
+	// readable by the agent process.
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, xerrors.Errorf("write host key: %w", err)
+	}
+	return ssh.NewSignerFromKey(priv)
+}
+
+// reportSSHHostKey sends the public half of signer to coderd, so it can be
+// handed back to clients via codersdk.WorkspaceAgent.SSHHostKey. Init calls
+// it on every start; since the key is persisted locally, each call after the
+// first resends the same value, and coderd just overwrites it.
+func reportSSHHostKey(ctx context.Context, client *codersdk.Client, agentID uuid.UUID, signer ssh.Signer) error {
+	// ssh.MarshalAuthorizedKey always terminates the line with "\n"; trim it
+	// so the value coderd stores - and later hands back verbatim through
+	// codersdk.WorkspaceAgent.SSHHostKey - is a single bare line. Otherwise
+	// every consumer that writes it into a known_hosts entry followed by its
+	// own "\n" (see configSSH) ends up with a blank line per entry.
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	res, err := client.Request(ctx, http.MethodPost, "/api/v2/workspaceagents/"+agentID.String()+"/ssh-host-key",
+		codersdk.PostWorkspaceAgentSSHHostKeyRequest{SSHHostKey: line})
+	if err != nil {
+		return xerrors.Errorf("report ssh host key: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return codersdk.ReadBodyAsError(res)
+	}
+	return nil
+}
@@ -6,7 +6,6 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
 
 	"github.com/cli/safeexec"
 	"github.com/spf13/cobra"
@@ -19,25 +18,77 @@ import (
 	"github.com/coder/coder/codersdk"
 )
 
-const sshStartToken = "# ------------START-CODER-----------"
-const sshStartMessage = `# This was generated by "coder config-ssh".
+// sshKnownHostsFileName is the name of the file, stored alongside the global
+// config, that pins the host keys coderd reports for each workspace agent.
+// Referencing it via UserKnownHostsFile lets us keep StrictHostKeyChecking
+// enabled instead of disabling verification outright.
+const sshKnownHostsFileName = "coder_known_hosts"
+
+const sshConfigHeader = `# This file is managed by "coder config-ssh".
+#
+# To regenerate it, run:
 #
-# To remove this blob, run:
+#    coder config-ssh
+#
+# To remove it and stop coder from managing your SSH config, run:
 #
 #    coder config-ssh --remove
 #
-# You should not hand-edit this section, unless you are deleting it.`
-const sshEndToken = "# ------------END-CODER------------"
+# You should not hand-edit this file, unless you are deleting it.`
+
+// sshConfigIncludeLine is what we ensure is present, verbatim, in the user's
+// real SSH config (added at the top when it's missing). OpenSSH 7.3+'s Include directive means our
+// managed stanzas can live in their own file instead of being spliced into
+// the user's; a partial write or merge conflict in the include target can no
+// longer corrupt anything the user wrote themselves.
+func sshConfigIncludeLine(coderConfigFile string) string {
+	return fmt.Sprintf("Include %q", coderConfigFile)
+}
 
 func configSSH() *cobra.Command {
 	var (
 		sshConfigFile    string
+		coderConfigFile  string
 		sshOptions       []string
 		skipProxyCommand bool
+		sshClient        string
+		sshVariantFlag   string
+		remove           bool
 	)
 	cmd := &cobra.Command{
 		Use: "config-ssh",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			dirname, _ := os.UserHomeDir()
+			if strings.HasPrefix(sshConfigFile, "~/") {
+				sshConfigFile = filepath.Join(dirname, sshConfigFile[2:])
+			}
+			if strings.HasPrefix(coderConfigFile, "~/") {
+				coderConfigFile = filepath.Join(dirname, coderConfigFile[2:])
+			}
+
+			if remove {
+				err := os.Remove(coderConfigFile)
+				if err != nil && !os.IsNotExist(err) {
+					return xerrors.Errorf("remove %q: %w", coderConfigFile, err)
+				}
+				removedLegacy, err := removeLegacySSHConfigBlock(sshConfigFile)
+				if err != nil {
+					return xerrors.Errorf("remove legacy stanzas from %q: %w", sshConfigFile, err)
+				}
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Removed the coder-managed SSH config at %q\n", coderConfigFile)
+				if removedLegacy {
+					_, _ = fmt.Fprintf(cmd.OutOrStdout(), "Removed the legacy inline stanzas from %q\n", sshConfigFile)
+				}
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "You may also want to remove the \"%s\" line from %q\n", sshConfigIncludeLine(coderConfigFile), sshConfigFile)
+				return nil
+			}
+
+			switch sshClientKind(sshClient) {
+			case sshClientOpenSSH, sshClientNative:
+			default:
+				return xerrors.Errorf("unknown --client %q, expected %q or %q; use --ssh-variant to pick a PuTTY-family client instead", sshClient, sshClientOpenSSH, sshClientNative)
+			}
+
 			client, err := createClient(cmd)
 			if err != nil {
 				return err
@@ -46,18 +97,6 @@ func configSSH() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			if strings.HasPrefix(sshConfigFile, "~/") {
-				dirname, _ := os.UserHomeDir()
-				sshConfigFile = filepath.Join(dirname, sshConfigFile[2:])
-			}
-			// Doesn't matter if this fails, because we write the file anyways.
-			sshConfigContentRaw, _ := os.ReadFile(sshConfigFile)
-			sshConfigContent := string(sshConfigContentRaw)
-			startIndex := strings.Index(sshConfigContent, sshStartToken)
-			endIndex := strings.Index(sshConfigContent, sshEndToken)
-			if startIndex != -1 && endIndex != -1 {
-				sshConfigContent = sshConfigContent[:startIndex-1] + sshConfigContent[endIndex+len(sshEndToken):]
-			}
 
 			workspaces, err := client.WorkspacesByOwner(cmd.Context(), organization.ID, codersdk.Me)
 			if err != nil {
@@ -72,51 +111,85 @@ func configSSH() *cobra.Command {
 				return err
 			}
 
+			variant := sshVariant(sshVariantFlag)
+			if variant == "" {
+				variant = detectSSHVariant()
+			}
+			configWriter, err := sshConfigWriterFor(variant)
+			if err != nil {
+				return err
+			}
+
 			root := createConfig(cmd)
-			sshConfigContent += "\n" + sshStartToken + "\n" + sshStartMessage + "\n\n"
-			sshConfigContentMutex := sync.Mutex{}
+			knownHostsPath := filepath.Join(string(root), sshKnownHostsFileName)
+
+			// Each workspace writes to its own slot, so goroutines never touch
+			// shared mutable state and there's no mutex guarding a concatenated
+			// buffer to get wrong.
+			nativeMode := sshClientKind(sshClient) == sshClientNative && variant == sshVariantSSH
+
+			stanzas := make([]string, len(workspaces))
+			knownHostsEntries := make([]string, len(workspaces))
+			nativeHostnames := make([][]string, len(workspaces))
 			var errGroup errgroup.Group
-			for _, workspace := range workspaces {
-				workspace := workspace
+			for i, workspace := range workspaces {
+				i, workspace := i, workspace
 				errGroup.Go(func() error {
 					resources, err := client.TemplateVersionResources(cmd.Context(), workspace.LatestBuild.TemplateVersionID)
 					if err != nil {
 						return err
 					}
+					var configBuilder strings.Builder
+					var knownHostsBuilder strings.Builder
+					var natives []string
 					for _, resource := range resources {
 						if resource.Transition != database.WorkspaceTransitionStart {
 							continue
 						}
 						for _, agent := range resource.Agents {
-							sshConfigContentMutex.Lock()
+							if err := validateSSHName("workspace", workspace.Name); err != nil {
+								return err
+							}
 							hostname := workspace.Name
 							if len(resource.Agents) > 1 {
+								if err := validateSSHName("agent", agent.Name); err != nil {
+									return err
+								}
 								hostname += "." + agent.Name
 							}
-							configOptions := []string{
-								"Host coder." + hostname,
+							if nativeMode {
+								// The native client speaks SSH itself; there's no
+								// OpenSSH stanza that can hand a connection to it
+								// (a Match exec block only runs a command to test
+								// its exit code, it can't proxy a session). Users
+								// connect directly with "coder ssh --client=native".
+								natives = append(natives, hostname)
+								continue
 							}
-							for _, option := range sshOptions {
-								configOptions = append(configOptions, "\t"+option)
+							configOptions, err := configWriter.WriteStanza(sshStanzaOptions{
+								Hostname:         hostname,
+								BinaryFile:       binaryFile,
+								GlobalConfigPath: string(root),
+								KnownHostsPath:   knownHostsPath,
+								ExtraOptions:     sshOptions,
+								SkipProxyCommand: skipProxyCommand,
+								HasHostKey:       agent.SSHHostKey != "",
+							})
+							if err != nil {
+								return xerrors.Errorf("write stanza for %q: %w", hostname, err)
 							}
-							configOptions = append(configOptions,
-								"\tHostName coder."+hostname,
-								"\tConnectTimeout=0",
-								"\tStrictHostKeyChecking=no",
-								// Without this, the "REMOTE HOST IDENTITY CHANGED"
-								// message will appear.
-								"\tUserKnownHostsFile=/dev/null",
-								// This disables the "Warning: Permanently added 'hostname' (RSA) to the list of known hosts."
-								// message from appearing on every SSH. This happens because we ignore the known hosts.
-								"\tLogLevel ERROR",
-							)
-							if !skipProxyCommand {
-								configOptions = append(configOptions, fmt.Sprintf("\tProxyCommand %q --global-config %q ssh --stdio %s", binaryFile, root, hostname))
+							if len(configOptions) > 0 {
+								configBuilder.WriteString(strings.Join(configOptions, "\n"))
+								configBuilder.WriteString("\n")
+							}
+							if variant == sshVariantSSH && agent.SSHHostKey != "" {
+								fmt.Fprintf(&knownHostsBuilder, "coder.%s %s\n", hostname, agent.SSHHostKey)
 							}
-							sshConfigContent += strings.Join(configOptions, "\n") + "\n"
-							sshConfigContentMutex.Unlock()
 						}
 					}
+					stanzas[i] = configBuilder.String()
+					knownHostsEntries[i] = knownHostsBuilder.String()
+					nativeHostnames[i] = natives
 					return nil
 				})
 			}
@@ -124,29 +197,148 @@ func configSSH() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			sshConfigContent += "\n" + sshEndToken
-			err = os.MkdirAll(filepath.Dir(sshConfigFile), os.ModePerm)
+
+			coderConfigContent := sshConfigHeader + "\n\n" + strings.Join(stanzas, "")
+			err = os.MkdirAll(filepath.Dir(coderConfigFile), os.ModePerm)
 			if err != nil {
 				return err
 			}
-			err = os.WriteFile(sshConfigFile, []byte(sshConfigContent), os.ModePerm)
+			err = os.WriteFile(coderConfigFile, []byte(coderConfigContent), 0o600)
 			if err != nil {
-				return err
+				return xerrors.Errorf("write %q: %w", coderConfigFile, err)
+			}
+
+			// The known hosts file only needs to be readable by the user
+			// running config-ssh; it isn't sensitive, but there's no reason
+			// to make it world-readable.
+			err = os.WriteFile(knownHostsPath, []byte(strings.Join(knownHostsEntries, "")), 0o600)
+			if err != nil {
+				return xerrors.Errorf("write known hosts file: %w", err)
+			}
+
+			err = ensureSSHConfigIncludes(sshConfigFile, coderConfigFile)
+			if err != nil {
+				return xerrors.Errorf("ensure %q includes %q: %w", sshConfigFile, coderConfigFile, err)
 			}
-			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "An auto-generated ssh config was written to %q\n", sshConfigFile)
+
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "An auto-generated ssh config was written to %q\n", coderConfigFile)
 			_, _ = fmt.Fprintln(cmd.OutOrStdout(), "You should now be able to ssh into your workspace")
 			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "For example, try running\n\n\t$ ssh coder.%s\n\n", workspaces[0].Name)
+
+			if nativeMode {
+				// There's no ~/.ssh/config entry that can hand a connection to
+				// the native client, so tell the user how to reach these
+				// workspaces instead of silently omitting them.
+				_, _ = fmt.Fprintln(cmd.OutOrStdout(), "The following workspaces use --client=native and have no ssh config entry;")
+				_, _ = fmt.Fprintln(cmd.OutOrStdout(), "connect to them directly instead:")
+				for _, hostnames := range nativeHostnames {
+					for _, hostname := range hostnames {
+						_, _ = fmt.Fprintf(cmd.OutOrStdout(), "\t$ coder ssh --client=native %s\n", hostname)
+					}
+				}
+			}
 			return nil
 		},
 	}
-	cliflag.StringVarP(cmd.Flags(), &sshConfigFile, "ssh-config-file", "", "CODER_SSH_CONFIG_FILE", "~/.ssh/config", "Specifies the path to an SSH config.")
+	cliflag.StringVarP(cmd.Flags(), &sshConfigFile, "ssh-config-file", "", "CODER_SSH_CONFIG_FILE", "~/.ssh/config", "Specifies the path to an SSH config that will Include the coder-managed config.")
+	cliflag.StringVarP(cmd.Flags(), &coderConfigFile, "ssh-coder-config-file", "", "CODER_SSH_CODER_CONFIG_FILE", "~/.ssh/coder", "Specifies the path to the coder-managed SSH config, included from --ssh-config-file.")
 	cmd.Flags().StringArrayVarP(&sshOptions, "ssh-option", "o", []string{}, "Specifies additional SSH options to embed in each host stanza.")
 	cmd.Flags().BoolVarP(&skipProxyCommand, "skip-proxy-command", "", false, "Specifies whether the ProxyCommand option should be skipped. Useful for testing.")
 	_ = cmd.Flags().MarkHidden("skip-proxy-command")
+	cmd.Flags().StringVar(&sshClient, "client", string(sshClientOpenSSH), "Specifies which SSH implementation the generated config hands connections to: openssh (default, execs the system ssh binary) or native (speaks SSH in Go via \"coder ssh --client=native\", no ssh binary required). Only applies to the \"ssh\" --ssh-variant; use --ssh-variant to target PuTTY-family clients instead.")
+	cmd.Flags().StringVar(&sshVariantFlag, "ssh-variant", "", "Specifies the SSH client variant to render a stanza for: ssh, putty, plink, or tortoiseplink. Detected automatically from $GIT_SSH, $GIT_SSH_VARIANT, or plink/tortoiseplink on %PATH% when unset.")
+	cmd.Flags().BoolVar(&remove, "remove", false, "Remove the coder-managed SSH config file, and any legacy inline coder block in --ssh-config-file. The Include line in --ssh-config-file is left in place; you'll want to remove it yourself.")
 
 	return cmd
 }
 
+// ensureSSHConfigIncludes idempotently makes sure sshConfigFile contains an
+// "Include coderConfigFile" line (prepending one when no line of the file
+// matches it), so OpenSSH picks up our generated
+// stanzas, and that it no longer carries the legacy token-spliced block an
+// older config-ssh may have left behind. Past the initial migration, it
+// doesn't need to touch anything past the first line: nothing we write here
+// can race with, or be corrupted by, concurrent edits to the rest of the
+// file.
+func ensureSSHConfigIncludes(sshConfigFile, coderConfigFile string) error {
+	includeLine := sshConfigIncludeLine(coderConfigFile)
+
+	existingRaw, err := os.ReadFile(sshConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existing, migrated := stripLegacySSHConfigBlock(string(existingRaw))
+
+	hasInclude := false
+	for _, line := range strings.Split(existing, "\n") {
+		if strings.TrimSpace(line) == includeLine {
+			hasInclude = true
+			break
+		}
+	}
+	if hasInclude && !migrated {
+		return nil
+	}
+	if !hasInclude {
+		existing = includeLine + "\n" + existing
+	}
+
+	err = os.MkdirAll(filepath.Dir(sshConfigFile), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sshConfigFile, []byte(existing), os.ModePerm)
+}
+
+// sshLegacyConfigStartToken and sshLegacyConfigEndToken bound the block the
+// pre-Include config-ssh spliced directly into ~/.ssh/config.
+const (
+	sshLegacyConfigStartToken = "# ------------START-CODER-----------"
+	sshLegacyConfigEndToken   = "# ------------END-CODER------------"
+)
+
+// stripLegacySSHConfigBlock removes the inline Host stanzas the old
+// token-splice approach wrote directly into ~/.ssh/config, between
+// sshLegacyConfigStartToken and sshLegacyConfigEndToken (inclusive).
+// Upgrading users would otherwise keep two copies of every workspace's
+// stanza after switching to the Include-based config: the stale spliced one
+// (permanently pinned to StrictHostKeyChecking=no) and the new generated
+// one, with OpenSSH using whichever Host block it encounters first.
+func stripLegacySSHConfigBlock(content string) (result string, removed bool) {
+	start := strings.Index(content, sshLegacyConfigStartToken)
+	if start == -1 {
+		return content, false
+	}
+	relEnd := strings.Index(content[start:], sshLegacyConfigEndToken)
+	if relEnd == -1 {
+		return content, false
+	}
+	end := start + relEnd + len(sshLegacyConfigEndToken)
+	if end < len(content) && content[end] == '\n' {
+		end++
+	}
+	return content[:start] + content[end:], true
+}
+
+// removeLegacySSHConfigBlock strips the legacy token-spliced block (see
+// stripLegacySSHConfigBlock) from sshConfigFile in place, for "config-ssh
+// --remove" users who never ran config-ssh again after upgrading to pick up
+// the migration in ensureSSHConfigIncludes.
+func removeLegacySSHConfigBlock(sshConfigFile string) (removed bool, err error) {
+	existingRaw, err := os.ReadFile(sshConfigFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	stripped, removed := stripLegacySSHConfigBlock(string(existingRaw))
+	if !removed {
+		return false, nil
+	}
+	return true, os.WriteFile(sshConfigFile, []byte(stripped), os.ModePerm)
+}
+
 // currentBinPath returns the path to the coder binary suitable for use in ssh
 // ProxyCommand.
 func currentBinPath(cmd *cobra.Command) (string, error) {
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnsureSSHConfigIncludes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("CreatesMissingFile", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		sshConfigFile := filepath.Join(dir, "config")
+		coderConfigFile := filepath.Join(dir, "coder")
+
+		err := ensureSSHConfigIncludes(sshConfigFile, coderConfigFile)
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(sshConfigFile)
+		require.NoError(t, err)
+		require.Contains(t, string(content), sshConfigIncludeLine(coderConfigFile))
+	})
+
+	t.Run("PreservesExistingContent", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		sshConfigFile := filepath.Join(dir, "config")
+		coderConfigFile := filepath.Join(dir, "coder")
+		require.NoError(t, os.WriteFile(sshConfigFile, []byte("Host myserver\n\tHostName example.com\n"), 0o600))
+
+		err := ensureSSHConfigIncludes(sshConfigFile, coderConfigFile)
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(sshConfigFile)
+		require.NoError(t, err)
+		require.Contains(t, string(content), "Host myserver")
+		require.Contains(t, string(content), sshConfigIncludeLine(coderConfigFile))
+	})
+
+	t.Run("Idempotent", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		sshConfigFile := filepath.Join(dir, "config")
+		coderConfigFile := filepath.Join(dir, "coder")
+
+		require.NoError(t, ensureSSHConfigIncludes(sshConfigFile, coderConfigFile))
+		require.NoError(t, ensureSSHConfigIncludes(sshConfigFile, coderConfigFile))
+
+		content, err := os.ReadFile(sshConfigFile)
+		require.NoError(t, err)
+		require.Equal(t, 1, strings.Count(string(content), sshConfigIncludeLine(coderConfigFile)))
+	})
+
+	t.Run("QuotesPathWithSpaces", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		sshConfigFile := filepath.Join(dir, "My Documents", "config")
+		coderConfigFile := filepath.Join(dir, "My Documents", "coder")
+
+		require.NoError(t, ensureSSHConfigIncludes(sshConfigFile, coderConfigFile))
+		// Idempotency check must also survive the quoting: a second run
+		// shouldn't fail to recognize its own previously written line and
+		// double it up.
+		require.NoError(t, ensureSSHConfigIncludes(sshConfigFile, coderConfigFile))
+
+		content, err := os.ReadFile(sshConfigFile)
+		require.NoError(t, err)
+		require.Equal(t, 1, strings.Count(string(content), sshConfigIncludeLine(coderConfigFile)))
+		require.Contains(t, string(content), fmt.Sprintf("Include %q", coderConfigFile))
+	})
+
+	t.Run("MigratesLegacyTokenSplice", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		sshConfigFile := filepath.Join(dir, "config")
+		coderConfigFile := filepath.Join(dir, "coder")
+		legacyBlock := "# ------------START-CODER-----------\nHost coder.dev\n\tHostName coder.dev\n\tStrictHostKeyChecking=no\n# ------------END-CODER------------\n"
+		require.NoError(t, os.WriteFile(sshConfigFile, []byte("Host myserver\n\tHostName example.com\n"+legacyBlock), 0o600))
+
+		require.NoError(t, ensureSSHConfigIncludes(sshConfigFile, coderConfigFile))
+
+		content, err := os.ReadFile(sshConfigFile)
+		require.NoError(t, err)
+		require.Contains(t, string(content), "Host myserver")
+		require.Contains(t, string(content), sshConfigIncludeLine(coderConfigFile))
+		require.NotContains(t, string(content), "START-CODER")
+		require.NotContains(t, string(content), "StrictHostKeyChecking=no")
+
+		// Running again shouldn't re-add the include line or error now that
+		// the legacy block is gone.
+		require.NoError(t, ensureSSHConfigIncludes(sshConfigFile, coderConfigFile))
+		content, err = os.ReadFile(sshConfigFile)
+		require.NoError(t, err)
+		require.Equal(t, 1, strings.Count(string(content), sshConfigIncludeLine(coderConfigFile)))
+	})
+}
+
+func TestRemoveLegacySSHConfigBlock(t *testing.T) {
+	t.Parallel()
+
+	t.Run("RemovesBlock", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		sshConfigFile := filepath.Join(dir, "config")
+		legacyBlock := "# ------------START-CODER-----------\nHost coder.dev\n\tHostName coder.dev\n# ------------END-CODER------------\n"
+		require.NoError(t, os.WriteFile(sshConfigFile, []byte("Host myserver\n"+legacyBlock), 0o600))
+
+		removed, err := removeLegacySSHConfigBlock(sshConfigFile)
+		require.NoError(t, err)
+		require.True(t, removed)
+
+		content, err := os.ReadFile(sshConfigFile)
+		require.NoError(t, err)
+		require.Equal(t, "Host myserver\n", string(content))
+	})
+
+	t.Run("NoBlockIsNoop", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		sshConfigFile := filepath.Join(dir, "config")
+		require.NoError(t, os.WriteFile(sshConfigFile, []byte("Host myserver\n"), 0o600))
+
+		removed, err := removeLegacySSHConfigBlock(sshConfigFile)
+		require.NoError(t, err)
+		require.False(t, removed)
+	})
+
+	t.Run("MissingFileIsNoop", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		removed, err := removeLegacySSHConfigBlock(filepath.Join(dir, "config"))
+		require.NoError(t, err)
+		require.False(t, removed)
+	})
+}
@@ -0,0 +1,256 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/cli/safeexec"
+	"golang.org/x/xerrors"
+)
+
+// validSSHNameComponent matches the characters we're willing to interpolate
+// into a Host/HostName line or a ProxyCommand argument. It intentionally
+// excludes anything an SSH client, the system shell, or a batch/registry
+// file could interpret specially.
+var validSSHNameComponent = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validateSSHName rejects workspace/agent names that could smuggle an SSH
+// option (e.g. "-oProxyCommand=evil"), break out of a single Host stanza via
+// embedded whitespace or newlines, or otherwise escape the context they're
+// interpolated into. configSSH calls this before rendering a stanza, and
+// `coder ssh` calls it again on its own argument so a future change to
+// either call site can't reintroduce the hole.
+func validateSSHName(kind, name string) error {
+	if name == "" {
+		return xerrors.Errorf("%s name is empty", kind)
+	}
+	if strings.HasPrefix(name, "-") {
+		return xerrors.Errorf("%s name %q begins with \"-\", which could be parsed as an SSH option", kind, name)
+	}
+	if !validSSHNameComponent.MatchString(name) {
+		return xerrors.Errorf("%s name %q contains characters outside [A-Za-z0-9_.-]", kind, name)
+	}
+	return nil
+}
+
+// sshVariant identifies the SSH client flavor configSSH is generating a
+// stanza for. git-lfs enumerates the same set for the same reason: ssh,
+// putty, and plink/tortoiseplink all expect incompatible argument and config
+// syntax, so the code rendering a stanza needs to know which one it's
+// talking to before it can get the syntax right.
+type sshVariant string
+
+const (
+	sshVariantSSH           sshVariant = "ssh"
+	sshVariantPutty         sshVariant = "putty"
+	sshVariantPlink         sshVariant = "plink"
+	sshVariantTortoisePlink sshVariant = "tortoiseplink"
+)
+
+// detectSSHVariant mirrors git-lfs's approach to picking an ssh.exe stand-in:
+// prefer an explicit override, fall back to $GIT_SSH/$GIT_SSH_VARIANT, and
+// finally look for plink/tortoiseplink on %PATH% before assuming plain
+// OpenSSH.
+func detectSSHVariant() sshVariant {
+	if variant := os.Getenv("GIT_SSH_VARIANT"); variant != "" {
+		return sshVariant(variant)
+	}
+	if gitSSH := os.Getenv("GIT_SSH"); gitSSH != "" {
+		return sshVariantFromBinaryName(filepath.Base(gitSSH))
+	}
+	if runtime.GOOS == "windows" {
+		if _, err := safeexec.LookPath("tortoiseplink.exe"); err == nil {
+			return sshVariantTortoisePlink
+		}
+		if _, err := safeexec.LookPath("plink.exe"); err == nil {
+			return sshVariantPlink
+		}
+	}
+	return sshVariantSSH
+}
+
+func sshVariantFromBinaryName(name string) sshVariant {
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "tortoiseplink"):
+		return sshVariantTortoisePlink
+	case strings.Contains(name, "plink"):
+		return sshVariantPlink
+	case strings.Contains(name, "putty"):
+		return sshVariantPutty
+	default:
+		return sshVariantSSH
+	}
+}
+
+// sshStanzaOptions carries everything a sshConfigWriter needs to render a
+// single workspace agent's entry, independent of which client variant it's
+// rendering for.
+type sshStanzaOptions struct {
+	Hostname         string
+	BinaryFile       string
+	GlobalConfigPath string
+	KnownHostsPath   string
+	ExtraOptions     []string
+	SkipProxyCommand bool
+	// HasHostKey is true once the agent has reported a persistent SSH host
+	// key for KnownHostsPath to pin. Agents that haven't (older builds, or
+	// one that hasn't completed its first handshake with coderd yet) fall
+	// back to the old StrictHostKeyChecking=no behavior instead of leaving
+	// the user with a host that can never pass verification.
+	HasHostKey bool
+}
+
+// sshConfigWriter renders the per-workspace-agent block configSSH appends to
+// the generated config, writing any side files (PuTTY saved sessions,
+// wrapper scripts) the stanza depends on along the way. Each variant that
+// expects incompatible syntax gets its own implementation so configSSH's
+// main loop doesn't need to know the details.
+type sshConfigWriter interface {
+	WriteStanza(opts sshStanzaOptions) ([]string, error)
+}
+
+func sshConfigWriterFor(variant sshVariant) (sshConfigWriter, error) {
+	switch variant {
+	case sshVariantSSH, "":
+		return opensshConfigWriter{}, nil
+	case sshVariantPutty:
+		return puttyConfigWriter{}, nil
+	case sshVariantPlink:
+		return plinkConfigWriter{binaryName: "plink"}, nil
+	case sshVariantTortoisePlink:
+		return plinkConfigWriter{binaryName: "tortoiseplink"}, nil
+	default:
+		return nil, xerrors.Errorf("unknown ssh variant %q", variant)
+	}
+}
+
+// opensshConfigWriter renders the Host stanza OpenSSH expects.
+type opensshConfigWriter struct{}
+
+func (opensshConfigWriter) WriteStanza(opts sshStanzaOptions) ([]string, error) {
+	lines := []string{"Host coder." + opts.Hostname}
+	for _, option := range opts.ExtraOptions {
+		lines = append(lines, "\t"+option)
+	}
+	lines = append(lines,
+		"\tHostName coder."+opts.Hostname,
+		"\tConnectTimeout=0",
+	)
+	if opts.HasHostKey {
+		lines = append(lines,
+			"\tStrictHostKeyChecking=yes",
+			fmt.Sprintf("\tUserKnownHostsFile=%q", opts.KnownHostsPath),
+			// This disables the "Warning: Permanently added 'hostname' (RSA) to the list of known hosts."
+			// message from appearing on every SSH. We already control whether a host key is trusted
+			// via KnownHostsPath, so this output is just noise.
+			"\tLogLevel ERROR",
+		)
+	} else {
+		// No persisted host key reported yet; fall back to the old behavior
+		// rather than leaving the host permanently unverifiable.
+		lines = append(lines,
+			"\tStrictHostKeyChecking=no",
+			"\tUserKnownHostsFile=/dev/null",
+			"\tLogLevel ERROR",
+		)
+	}
+	if !opts.SkipProxyCommand {
+		lines = append(lines, fmt.Sprintf("\tProxyCommand %q --global-config %q ssh --stdio -- %s", opts.BinaryFile, opts.GlobalConfigPath, opts.Hostname))
+	}
+	return lines, nil
+}
+
+// plinkConfigWriter renders an OpenSSH Host stanza whose ProxyCommand runs
+// Plink/TortoisePlink, for the common case of Plink being driven as a
+// $GIT_SSH or ProxyCommand helper rather than loaded as a saved PuTTY
+// session. Plink's own argument syntax is `plink [flags] [user@]host
+// [command]`, so the first non-flag token it sees is taken as its *target*
+// host - naively appending our "<coder> ssh --stdio" invocation as trailing
+// arguments would hand it the coder binary's path as that target instead of
+// connecting anywhere. Pointing plink's `-proxycmd` flag at a generated
+// wrapper script (the same one puttyConfigWriter writes) keeps plink's
+// positional argument as the real host, while the wrapper becomes the
+// transport plink tunnels through - avoiding nesting a second, separately
+// shell-quoted command line inside ProxyCommand's own.
+type plinkConfigWriter struct {
+	binaryName string
+}
+
+func (w plinkConfigWriter) WriteStanza(opts sshStanzaOptions) ([]string, error) {
+	wrapperPath, err := writePuttyWrapperScript(opts)
+	if err != nil {
+		return nil, xerrors.Errorf("write plink wrapper script: %w", err)
+	}
+
+	lines := []string{"Host coder." + opts.Hostname}
+	for _, option := range opts.ExtraOptions {
+		lines = append(lines, "\t"+option)
+	}
+	lines = append(lines, "\tHostName coder."+opts.Hostname)
+	if !opts.SkipProxyCommand {
+		lines = append(lines, fmt.Sprintf("\tProxyCommand %s -batch -agent -proxycmd %q coder.%s", w.binaryName, wrapperPath, opts.Hostname))
+	}
+	return lines, nil
+}
+
+// puttyConfigWriter doesn't use ~/.ssh/config at all: PuTTY keeps its own
+// saved-session store in the registry. Instead it writes a saved session
+// pointing ProxyCommand-equivalent ("proxycmd" in PuTTY's terms) at a
+// generated wrapper batch file, and emits no lines for the OpenSSH config.
+// Users connect with `putty -load coder.<hostname>`.
+type puttyConfigWriter struct{}
+
+func (puttyConfigWriter) WriteStanza(opts sshStanzaOptions) ([]string, error) {
+	wrapperPath, err := writePuttyWrapperScript(opts)
+	if err != nil {
+		return nil, xerrors.Errorf("write putty wrapper script: %w", err)
+	}
+	if err := writePuttySessionRegEntry(opts, wrapperPath); err != nil {
+		return nil, xerrors.Errorf("write putty session registry entry: %w", err)
+	}
+	// Nothing to add to ~/.ssh/config; the saved session is how PuTTY/KiTTY
+	// users will connect (e.g. `putty -load coder.<hostname>`).
+	return nil, nil
+}
+
+// writePuttyWrapperScript generates a small batch file that shells out to
+// this binary's --stdio mode, since PuTTY's "proxycmd" setting is a single
+// command line rather than the OpenSSH ProxyCommand token syntax.
+func writePuttyWrapperScript(opts sshStanzaOptions) (string, error) {
+	dir := filepath.Dir(opts.GlobalConfigPath)
+	scriptPath := filepath.Join(dir, "putty", "coder."+opts.Hostname+".bat")
+	if err := os.MkdirAll(filepath.Dir(scriptPath), 0o755); err != nil {
+		return "", err
+	}
+	contents := fmt.Sprintf("@echo off\r\n%q --global-config %q ssh --stdio -- %s\r\n", opts.BinaryFile, opts.GlobalConfigPath, opts.Hostname)
+	if err := os.WriteFile(scriptPath, []byte(contents), 0o755); err != nil { //nolint:gosec
+		return "", err
+	}
+	return scriptPath, nil
+}
+
+// writePuttySessionRegEntry generates a .reg file the user can import to
+// create a saved PuTTY session for this workspace. We write a .reg file
+// rather than touching the registry directly so config-ssh doesn't need
+// elevated privileges and the change stays inspectable before being applied.
+func writePuttySessionRegEntry(opts sshStanzaOptions, wrapperPath string) error {
+	dir := filepath.Dir(opts.GlobalConfigPath)
+	regPath := filepath.Join(dir, "putty", "coder."+opts.Hostname+".reg")
+	if err := os.MkdirAll(filepath.Dir(regPath), 0o755); err != nil {
+		return err
+	}
+	sessionName := "coder." + opts.Hostname
+	contents := fmt.Sprintf(`Windows Registry Editor Version 5.00
+
+[HKEY_CURRENT_USER\Software\SimonTatham\PuTTY\Sessions\%s]
+"HostName"="coder.%s"
+"ProxyMethod"=dword:00000005
+"ProxyTelnetCommand"="%s"
+`, sessionName, opts.Hostname, strings.ReplaceAll(wrapperPath, `\`, `\\`))
+	return os.WriteFile(regPath, []byte(contents), 0o644) //nolint:gosec
+}
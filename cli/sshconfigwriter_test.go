@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSSHName(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{name: "simple", input: "my-workspace", wantErr: false},
+		{name: "with dots", input: "dev.main", wantErr: false},
+		{name: "with underscore", input: "dev_box_1", wantErr: false},
+		{name: "empty", input: "", wantErr: true},
+		{name: "leading dash", input: "-oProxyCommand=evil", wantErr: true},
+		{name: "embedded whitespace", input: "host name", wantErr: true},
+		{name: "embedded newline", input: "host\nname", wantErr: true},
+		{name: "shell metacharacter", input: "host;rm -rf", wantErr: true},
+		{name: "path traversal characters", input: "../etc/passwd", wantErr: true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateSSHName("workspace", tc.input)
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestOpenSSHConfigWriterHostKeyFallback(t *testing.T) {
+	t.Parallel()
+
+	base := sshStanzaOptions{
+		Hostname:         "dev",
+		BinaryFile:       "coder",
+		GlobalConfigPath: "/home/user/.config/coderv2",
+		KnownHostsPath:   "/home/user/.config/coderv2/coder_known_hosts",
+	}
+
+	t.Run("WithHostKey", func(t *testing.T) {
+		t.Parallel()
+
+		opts := base
+		opts.HasHostKey = true
+		lines, err := opensshConfigWriter{}.WriteStanza(opts)
+		require.NoError(t, err)
+		require.Contains(t, lines, "\tStrictHostKeyChecking=yes")
+		require.Contains(t, lines, fmt.Sprintf("\tUserKnownHostsFile=%q", opts.KnownHostsPath))
+	})
+
+	t.Run("WithoutHostKey", func(t *testing.T) {
+		t.Parallel()
+
+		opts := base
+		opts.HasHostKey = false
+		lines, err := opensshConfigWriter{}.WriteStanza(opts)
+		require.NoError(t, err)
+		require.Contains(t, lines, "\tStrictHostKeyChecking=no")
+		require.Contains(t, lines, "\tUserKnownHostsFile=/dev/null")
+	})
+}
+
+func TestOpenSSHConfigWriterNoMatchExec(t *testing.T) {
+	t.Parallel()
+
+	// Regression test for a stanza that used `Match exec` to try to hand a
+	// connection to --client=native: Match is a conditional, not a proxy, so
+	// it produced no working ProxyCommand at all. Native-mode hosts should
+	// never reach opensshConfigWriter in the first place (configSSH skips
+	// them and tells the user to run "coder ssh --client=native" directly),
+	// so nothing it renders should mention a "Match" block.
+	lines, err := opensshConfigWriter{}.WriteStanza(sshStanzaOptions{
+		Hostname:         "dev",
+		BinaryFile:       "coder",
+		GlobalConfigPath: "/home/user/.config/coderv2",
+		KnownHostsPath:   "/home/user/.config/coderv2/coder_known_hosts",
+	})
+	require.NoError(t, err)
+	for _, line := range lines {
+		require.NotContains(t, line, "Match")
+	}
+	require.Contains(t, lines, fmt.Sprintf("\tProxyCommand %q --global-config %q ssh --stdio -- %s", "coder", "/home/user/.config/coderv2", "dev"))
+}
+
+func TestPuttyWriterWritesSavedSession(t *testing.T) {
+	t.Parallel()
+
+	// The GUI putty.exe never reads ~/.ssh/config; it loads a saved session
+	// from the registry instead, so sshVariantPutty should emit no stanza
+	// lines and write the wrapper + registry files a saved session needs.
+	dir := t.TempDir()
+	writer, err := sshConfigWriterFor(sshVariantPutty)
+	require.NoError(t, err)
+
+	lines, err := writer.WriteStanza(sshStanzaOptions{
+		Hostname:         "dev",
+		BinaryFile:       "coder",
+		GlobalConfigPath: filepath.Join(dir, "coderv2"),
+		KnownHostsPath:   filepath.Join(dir, "coderv2", "coder_known_hosts"),
+	})
+	require.NoError(t, err)
+	require.Empty(t, lines, "putty doesn't read ~/.ssh/config")
+
+	_, err = os.Stat(filepath.Join(dir, "putty", "coder.dev.bat"))
+	require.NoError(t, err, "expected a wrapper script for the saved session to proxy through")
+	_, err = os.Stat(filepath.Join(dir, "putty", "coder.dev.reg"))
+	require.NoError(t, err, "expected a saved-session registry entry")
+}
+
+func TestPlinkWriterProxyCommand(t *testing.T) {
+	t.Parallel()
+
+	// Plink/TortoisePlink are commonly driven as a $GIT_SSH or ProxyCommand
+	// helper rather than a saved session, so (unlike plain putty.exe) they
+	// need a real ~/.ssh/config stanza. Regression test for a ProxyCommand
+	// that appended the coder binary path as a bare positional argument:
+	// plink's CLI takes its *target host* as the first non-flag token, so
+	// that shape made plink try to connect to the coder binary's path
+	// instead of tunneling through it.
+	for _, tc := range []struct {
+		variant    sshVariant
+		binaryName string
+	}{
+		{sshVariantPlink, "plink"},
+		{sshVariantTortoisePlink, "tortoiseplink"},
+	} {
+		tc := tc
+		t.Run(string(tc.variant), func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			writer, err := sshConfigWriterFor(tc.variant)
+			require.NoError(t, err)
+
+			lines, err := writer.WriteStanza(sshStanzaOptions{
+				Hostname:         "dev",
+				BinaryFile:       "coder",
+				GlobalConfigPath: filepath.Join(dir, "coderv2"),
+				KnownHostsPath:   filepath.Join(dir, "coderv2", "coder_known_hosts"),
+			})
+			require.NoError(t, err)
+			require.Contains(t, lines, "Host coder.dev")
+			require.Contains(t, lines, "\tHostName coder.dev")
+
+			var proxyCommand string
+			for _, line := range lines {
+				if strings.HasPrefix(line, "\tProxyCommand ") {
+					proxyCommand = line
+				}
+			}
+			require.NotEmpty(t, proxyCommand, "expected a ProxyCommand line")
+			require.True(t, strings.HasPrefix(proxyCommand, "\tProxyCommand "+tc.binaryName+" -batch -agent -proxycmd "))
+			require.True(t, strings.HasSuffix(proxyCommand, " coder.dev"), "plink's trailing positional argument must be the real target host, not the coder binary path")
+
+			wrapperPath := filepath.Join(dir, "putty", "coder.dev.bat")
+			require.Contains(t, proxyCommand, wrapperPath)
+			_, err = os.Stat(wrapperPath)
+			require.NoError(t, err, "expected a wrapper script for -proxycmd to run")
+		})
+	}
+}
+
+func TestSSHVariantFromBinaryName(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name  string
+		input string
+		want  sshVariant
+	}{
+		{name: "plink", input: "plink.exe", want: sshVariantPlink},
+		{name: "tortoiseplink", input: "TortoisePlink.exe", want: sshVariantTortoisePlink},
+		{name: "putty", input: "putty.exe", want: sshVariantPutty},
+		{name: "unrecognized falls back to ssh", input: "ssh.exe", want: sshVariantSSH},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.want, sshVariantFromBinaryName(tc.input))
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package coderd
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"github.com/coder/coder/coderd/database"
+)
+
+// API holds the dependencies coderd's HTTP handlers are methods on.
+type API struct {
+	Database database.Store
+}
+
+// New constructs the API and returns a router that serves the workspace
+// agent routes under /api/v2/workspaceagents/{workspaceagent}: fetching the
+// agent and reporting its SSH host key. No other routes are registered here.
+func New(db database.Store) (*API, chi.Router) {
+	api := &API{Database: db}
+	r := chi.NewRouter()
+	r.Route("/api/v2/workspaceagents/{workspaceagent}", api.mustMountWorkspaceAgentSSHHostKeyRoutes)
+	return api, r
+}
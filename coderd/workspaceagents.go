@@ -0,0 +1,71 @@
+package coderd
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/coder/coder/coderd/httpapi"
+	"github.com/coder/coder/codersdk"
+)
+
+// workspaceAgent returns the workspace agent, including the public component
+// of its persisted SSH host key, so "coder config-ssh" can pin it into a
+// known_hosts file instead of disabling verification.
+func (api *API) workspaceAgent(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	agentID, err := uuid.Parse(chi.URLParam(r, "workspaceagent"))
+	if err != nil {
+		httpapi.Write(rw, http.StatusBadRequest, httpapi.Response{
+			Message: "workspace agent id must be a uuid",
+		})
+		return
+	}
+	agent, err := api.Database.GetWorkspaceAgentByID(ctx, agentID)
+	if err != nil {
+		httpapi.Write(rw, http.StatusNotFound, httpapi.Response{
+			Message: "workspace agent not found",
+		})
+		return
+	}
+	httpapi.Write(rw, http.StatusOK, codersdk.WorkspaceAgent{
+		ID:         agent.ID,
+		Name:       agent.Name,
+		SSHHostKey: agent.SSHHostKeyPublic,
+	})
+}
+
+// postWorkspaceAgentSSHHostKey is called by the agent on every start to
+// persist the public half of its SSH host key. Subsequent starts reuse the
+// same on-disk private key (see agent.loadOrGenerateHostKey) and so report
+// the same value, so the value stored here - and handed back by
+// workspaceAgent above - stays stable for the lifetime of the workspace.
+func (api *API) postWorkspaceAgentSSHHostKey(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	agentID, err := uuid.Parse(chi.URLParam(r, "workspaceagent"))
+	if err != nil {
+		httpapi.Write(rw, http.StatusBadRequest, httpapi.Response{
+			Message: "workspace agent id must be a uuid",
+		})
+		return
+	}
+	var req codersdk.PostWorkspaceAgentSSHHostKeyRequest
+	if !httpapi.Read(rw, r, &req) {
+		return
+	}
+	err = api.Database.UpdateWorkspaceAgentSSHHostKey(ctx, agentID, req.SSHHostKey)
+	if err != nil {
+		httpapi.Write(rw, http.StatusInternalServerError, httpapi.Response{
+			Message: "update workspace agent ssh host key",
+			Detail:  err.Error(),
+		})
+		return
+	}
+	httpapi.Write(rw, http.StatusNoContent, nil)
+}
+
+func (api *API) mustMountWorkspaceAgentSSHHostKeyRoutes(r chi.Router) {
+	r.Get("/", api.workspaceAgent)
+	r.Post("/ssh-host-key", api.postWorkspaceAgentSSHHostKey)
+}
@@ -0,0 +1,44 @@
+package codersdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// WorkspaceAgent describes a single agent running inside a workspace
+// resource.
+type WorkspaceAgent struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	// SSHHostKey is the public component, in authorized_keys format, of the
+	// persistent SSH host key the agent generated on first start. It lets
+	// "coder config-ssh" pin a known_hosts entry for the workspace instead of
+	// disabling host key verification. Empty for agents that haven't
+	// reported a host key yet (e.g. older agent builds, or an agent that
+	// hasn't completed its first handshake with coderd).
+	SSHHostKey string `json:"ssh_host_key,omitempty"`
+}
+
+// PostWorkspaceAgentSSHHostKeyRequest is sent by the agent on every start to
+// persist the public half of its SSH host key.
+type PostWorkspaceAgentSSHHostKeyRequest struct {
+	SSHHostKey string `json:"ssh_host_key"`
+}
+
+// WorkspaceAgent returns the workspace agent with the given ID.
+func (c *Client) WorkspaceAgent(ctx context.Context, id uuid.UUID) (WorkspaceAgent, error) {
+	res, err := c.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v2/workspaceagents/%s", id), nil)
+	if err != nil {
+		return WorkspaceAgent{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return WorkspaceAgent{}, ReadBodyAsError(res)
+	}
+	var agent WorkspaceAgent
+	return agent, json.NewDecoder(res.Body).Decode(&agent)
+}